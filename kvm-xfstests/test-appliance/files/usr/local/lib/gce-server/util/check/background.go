@@ -0,0 +1,92 @@
+package check
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// BackgroundCmd represents an external command started asynchronously by
+// StartBackground. It is modeled on the backgroundCmd type used by Go's
+// script test engine: the command runs in its own goroutine and the caller
+// collects its result later with Wait, instead of blocking on cmd.Run() for
+// the whole duration of a long xfstests invocation.
+type BackgroundCmd struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// StartBackground launches cmd asynchronously and returns immediately once
+// the process has started. The command is tied to ctx, so canceling ctx (or
+// letting its deadline expire) kills the process. Callers reap the result
+// with (*BackgroundCmd).Wait.
+func StartBackground(ctx context.Context, cmd *exec.Cmd, workDir string, env map[string]string, stdout io.Writer, stderr io.Writer) (*BackgroundCmd, error) {
+	cmd = withContext(ctx, cmd)
+	cmd.Dir = workDir
+	cmd.Env = parseEnv(env)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	bg := &BackgroundCmd{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		bg.err = cmd.Wait()
+		close(bg.done)
+	}()
+	return bg, nil
+}
+
+// Wait blocks until the background command completes and returns the error
+// from running it, or nil on success.
+func (b *BackgroundCmd) Wait() error {
+	<-b.done
+	return b.err
+}
+
+// Kill sends the background command's process a kill signal. It is safe to
+// call even if the process has already exited. Callers still need Wait to
+// reap the goroutine started by StartBackground.
+func (b *BackgroundCmd) Kill() error {
+	select {
+	case <-b.done:
+		return nil
+	default:
+		return b.cmd.Process.Kill()
+	}
+}
+
+// Runner tracks a set of commands started with StartBackground so that
+// callers, such as the GCE server dispatching concurrent gce-xfstests
+// shards, can reap all of them together instead of leaking goroutines.
+type Runner struct {
+	mu   sync.Mutex
+	cmds []*BackgroundCmd
+}
+
+// Track adds bg to the set of commands the Runner waits on.
+func (r *Runner) Track(bg *BackgroundCmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cmds = append(r.cmds, bg)
+}
+
+// WaitAll blocks until every tracked command has completed and returns their
+// errors in the order they were tracked, with nil for commands that
+// succeeded.
+func (r *Runner) WaitAll() []error {
+	r.mu.Lock()
+	cmds := r.cmds
+	r.cmds = nil
+	r.mu.Unlock()
+
+	errs := make([]error, len(cmds))
+	for i, bg := range cmds {
+		errs[i] = bg.Wait()
+	}
+	return errs
+}
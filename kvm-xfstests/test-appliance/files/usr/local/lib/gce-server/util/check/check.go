@@ -7,6 +7,7 @@ It also checks for errors and writes messages into logger.
 package check
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
@@ -25,41 +26,32 @@ var EmptyEnv = map[string]string{}
 
 // Run executes an external command and checks the return status.
 // Returns true on success and false otherwise.
+//
+// Deprecated: use RunContext so long-running commands can be canceled.
 func Run(cmd *exec.Cmd, workDir string, env map[string]string, stdout io.Writer, stderr io.Writer) error {
-	cmd.Dir = workDir
-	cmd.Env = parseEnv(env)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	err := cmd.Run()
-	return err
+	return RunContext(context.Background(), cmd, workDir, env, stdout, stderr)
 }
 
 // Output executes an external command, checks the return status, and
 // returns the command stdout.
+//
+// Deprecated: use OutputContext so long-running commands can be canceled.
 func Output(cmd *exec.Cmd, workDir string, env map[string]string, stderr io.Writer) (string, error) {
-	cmd.Dir = workDir
-	cmd.Env = parseEnv(env)
-	cmd.Stderr = stderr
-	out, err := cmd.Output()
-	return string(out), err
+	return OutputContext(context.Background(), cmd, workDir, env, stderr)
 }
 
 // CombinedOutput executes an external command, checks the return status, and
 // returns the combined stdout and stderr.
+//
+// Deprecated: use CombinedOutputContext so long-running commands can be
+// canceled.
 func CombinedOutput(cmd *exec.Cmd, workDir string, env map[string]string) (string, error) {
-	cmd.Dir = workDir
-	cmd.Env = parseEnv(env)
-	out, err := cmd.CombinedOutput()
-	return string(out), err
+	return CombinedOutputContext(context.Background(), cmd, workDir, env)
 }
 
 // parseEnv adds user specified environment to os.Environ.
 func parseEnv(env map[string]string) []string {
-	newEnv := os.Environ()
-	for key, value := range env {
-		newEnv = append(newEnv, key+"="+value)
-	}
-	return newEnv
+	return MergeEnv(os.Environ(), env)
 }
 
 // CreateDir creates a directory recursively with default permissions.
@@ -0,0 +1,67 @@
+package check
+
+import (
+	"context"
+	"io"
+	"os/exec"
+)
+
+// RunContext executes an external command and checks the return status,
+// killing the command if ctx is canceled or its deadline expires before the
+// command finishes. Returns nil on success and the command's error otherwise.
+func RunContext(ctx context.Context, cmd *exec.Cmd, workDir string, env map[string]string, stdout io.Writer, stderr io.Writer) error {
+	cmd = withContext(ctx, cmd)
+	cmd.Dir = workDir
+	cmd.Env = parseEnv(env)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	return err
+}
+
+// OutputContext executes an external command, checks the return status, and
+// returns the command stdout, killing the command if ctx is canceled or its
+// deadline expires before the command finishes.
+func OutputContext(ctx context.Context, cmd *exec.Cmd, workDir string, env map[string]string, stderr io.Writer) (string, error) {
+	cmd = withContext(ctx, cmd)
+	cmd.Dir = workDir
+	cmd.Env = parseEnv(env)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// CombinedOutputContext executes an external command, checks the return
+// status, and returns the combined stdout and stderr, killing the command
+// if ctx is canceled or its deadline expires before the command finishes.
+func CombinedOutputContext(ctx context.Context, cmd *exec.Cmd, workDir string, env map[string]string) (string, error) {
+	cmd = withContext(ctx, cmd)
+	cmd.Dir = workDir
+	cmd.Env = parseEnv(env)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// withContext rebuilds cmd as an equivalent command tied to ctx, so that
+// exec.CommandContext's cancellation semantics apply to *exec.Cmd values the
+// caller has already constructed with exec.Command. It copies every field
+// that affects how the command runs or is observed, not just Dir/Env/Stdin,
+// so replacing cmd is transparent to the caller.
+func withContext(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	newCmd := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	newCmd.Args[0] = cmd.Args[0]
+	newCmd.Dir = cmd.Dir
+	newCmd.Env = cmd.Env
+	newCmd.Stdin = cmd.Stdin
+	newCmd.Stdout = cmd.Stdout
+	newCmd.Stderr = cmd.Stderr
+	newCmd.ExtraFiles = cmd.ExtraFiles
+	newCmd.SysProcAttr = cmd.SysProcAttr
+	// Keep CommandContext's default Cancel (kill the process) unless the
+	// caller set its own; a nil Cancel would stop os/exec watching ctx.
+	if cmd.Cancel != nil {
+		newCmd.Cancel = cmd.Cancel
+	}
+	newCmd.WaitDelay = cmd.WaitDelay
+	return newCmd
+}
@@ -0,0 +1,104 @@
+package check
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ParseEnvFile reads an env file in the well-known KEY=value format used by
+// Docker's --env-file: one variable per line, blank lines and lines
+// starting with '#' are ignored, keys and values are trimmed of surrounding
+// whitespace, and a value may be wrapped in single or double quotes to
+// preserve leading/trailing whitespace.
+func ParseEnvFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	env := map[string]string{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.IndexByte(line, '=')
+		if idx < 0 {
+			return nil, fmt.Errorf("%s:%d: missing '=' in %q", path, i+1, line)
+		}
+
+		key := strings.TrimSpace(line[:idx])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid key in %q", path, i+1, line)
+		}
+
+		env[key] = unquoteEnvValue(strings.TrimSpace(line[idx+1:]))
+	}
+	return env, nil
+}
+
+// unquoteEnvValue strips a single matching pair of surrounding quotes from
+// an env file value, if present.
+func unquoteEnvValue(value string) string {
+	if len(value) >= 2 {
+		first, last := value[0], value[len(value)-1]
+		if (first == '"' && last == '"') || (first == '\'' && last == '\'') {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
+
+// LoadEnvFiles reads and merges a series of env files in order, with later
+// files taking precedence over earlier ones for keys they share.
+func LoadEnvFiles(paths ...string) (map[string]string, error) {
+	merged := map[string]string{}
+	for _, path := range paths {
+		env, err := ParseEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range env {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// MergeEnv builds a process environment by layering overlays on top of
+// base, a slice of "KEY=value" strings such as os.Environ(). Each overlay
+// is applied in order, and a key set by a later overlay (or occurring twice
+// within base) replaces the earlier value, instead of the caller ending up
+// with two entries for the same key and relying on os/exec's undocumented
+// last-wins behavior.
+func MergeEnv(base []string, overlays ...map[string]string) []string {
+	order := make([]string, 0, len(base))
+	values := make(map[string]string, len(base))
+	set := func(key, value string) {
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] = value
+	}
+
+	for _, kv := range base {
+		key, value := kv, ""
+		if idx := strings.IndexByte(kv, '='); idx >= 0 {
+			key, value = kv[:idx], kv[idx+1:]
+		}
+		set(key, value)
+	}
+	for _, overlay := range overlays {
+		for key, value := range overlay {
+			set(key, value)
+		}
+	}
+
+	merged := make([]string, 0, len(order))
+	for _, key := range order {
+		merged = append(merged, key+"="+values[key])
+	}
+	return merged
+}
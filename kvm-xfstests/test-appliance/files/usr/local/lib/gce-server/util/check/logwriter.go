@@ -0,0 +1,115 @@
+package check
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lineWriter splits whatever is written to it into lines and emits one
+// structured logrus entry per line, buffering any trailing partial line
+// until either a newline arrives or Close flushes it.
+type lineWriter struct {
+	log   *logrus.Entry
+	level logrus.Level
+	seq   uint64
+	buf   bytes.Buffer
+}
+
+func newLineWriter(log *logrus.Entry, level logrus.Level) *lineWriter {
+	return &lineWriter{log: log, level: level}
+}
+
+// Write implements io.Writer, logging each complete line in p and buffering
+// any trailing partial line for the next Write or Close.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// No newline yet; err is io.EOF and line holds whatever was read,
+			// which ReadString has already drained from the buffer.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.emit(line[:len(line)-1])
+	}
+	return len(p), nil
+}
+
+// Close flushes a buffered trailing partial line, if any, as a final log
+// entry. Call it once the process writing to w has exited.
+func (w *lineWriter) Close() error {
+	if w.buf.Len() > 0 {
+		w.emit(w.buf.String())
+		w.buf.Reset()
+	}
+	return nil
+}
+
+func (w *lineWriter) emit(line string) {
+	w.seq++
+	w.log.WithField("seq", w.seq).Log(w.level, line)
+}
+
+// LogWriter returns an io.Writer that logs whatever is written to it one
+// line at a time as structured entries at level, instead of leaving a
+// command's output opaque in a buffer until it exits. The returned writer
+// also implements io.Closer; call Close once the writer's source has
+// exited to flush any trailing partial line.
+func LogWriter(log *logrus.Entry, level logrus.Level) io.Writer {
+	return newLineWriter(log, level)
+}
+
+// RunLogged runs cmd like Run, but streams its stdout and stderr to log one
+// line at a time instead of collecting them into a buffer, so long xfstests
+// invocations are observable in real time rather than only after they
+// exit. Stdout lines are logged at Info level and stderr lines at Warn
+// level, each tagged with the command's path, pid, and a per-stream
+// sequence number.
+func RunLogged(cmd *exec.Cmd, workDir string, env map[string]string, log *logrus.Entry) error {
+	cmd.Dir = workDir
+	cmd.Env = parseEnv(env)
+
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Bake cmd/pid into each entry before handing it to the copier
+	// goroutines below, so the *logrus.Entry a goroutine holds is never
+	// mutated after it starts using it.
+	cmdLog := log.WithField("cmd", cmd.Path).WithField("pid", cmd.Process.Pid)
+	stdout := newLineWriter(cmdLog, logrus.InfoLevel)
+	stderr := newLineWriter(cmdLog, logrus.WarnLevel)
+
+	// exec.Cmd.Wait closes the pipes once the process exits, so we must
+	// finish reading from them before calling Wait.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(stdout, stdoutPipe)
+		stdout.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(stderr, stderrPipe)
+		stderr.Close()
+	}()
+	wg.Wait()
+
+	return cmd.Wait()
+}
@@ -0,0 +1,118 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os/exec"
+	"time"
+)
+
+// Decision tells RunWithPolicy what to do after a command attempt.
+type Decision int
+
+const (
+	// Fail stops retrying and returns the attempt's error.
+	Fail Decision = iota
+	// Retry runs the command again after backing off, unless MaxAttempts
+	// has been reached.
+	Retry
+	// Succeed stops retrying and returns nil, treating the attempt as a
+	// success even if the command exited with a non-zero status.
+	Succeed
+)
+
+// RetryPolicy configures how RunWithPolicy retries a flaky command, such as
+// gcloud, gsutil, or ssh calls that occasionally fail for transient
+// reasons that don't indicate a real test failure.
+type RetryPolicy struct {
+	// MaxAttempts caps the number of times the command is run, including
+	// the first attempt. A value less than 1 is treated as 1.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt. It doubles
+	// after each subsequent attempt, up to MaxBackoff.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the backoff delay. Zero means unbounded.
+	MaxBackoff time.Duration
+	// Jitter randomizes each backoff delay by up to this fraction in
+	// either direction, e.g. 0.1 for +/-10%.
+	Jitter float64
+	// Classify decides what to do after an attempt given its error and,
+	// if the command ran to completion, its exit code. A nil Classify
+	// treats a nil error as Succeed and any other error as Fail.
+	Classify func(err error, exitCode int) Decision
+}
+
+// ExitCode unwraps err looking for an *exec.ExitError and returns the
+// process's exit code and true. It returns (0, false) if err is nil or
+// doesn't carry an exit code, such as when the command never started.
+func ExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// RunWithPolicy runs cmd like RunContext, retrying it according to policy
+// when policy.Classify decides an attempt's failure was transient. It backs
+// off exponentially between attempts starting at policy.InitialBackoff, and
+// gives up after policy.MaxAttempts, returning the final attempt's error.
+// stdout and stderr are passed to every attempt unchanged, so their content
+// accumulates across retries rather than being replaced; pass writers that
+// are safe to append to repeatedly, or wrap them to reset between attempts
+// if only the final attempt's output matters.
+func RunWithPolicy(ctx context.Context, cmd *exec.Cmd, workDir string, env map[string]string, stdout io.Writer, stderr io.Writer, policy RetryPolicy) error {
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	backoff := policy.InitialBackoff
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = RunContext(ctx, cmd, workDir, env, stdout, stderr)
+
+		decision := Succeed
+		if err != nil {
+			decision = Fail
+		}
+		if policy.Classify != nil {
+			exitCode, _ := ExitCode(err)
+			decision = policy.Classify(err, exitCode)
+		}
+
+		if decision != Retry || attempt == maxAttempts {
+			if decision == Succeed {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitter(backoff, policy.Jitter)):
+		}
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+	return err
+}
+
+// jitter randomizes d by up to +/-frac, leaving it unchanged for frac <= 0.
+func jitter(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 {
+		return d
+	}
+	delta := float64(d) * frac
+	offset := (rand.Float64()*2 - 1) * delta
+	return time.Duration(float64(d) + offset)
+}
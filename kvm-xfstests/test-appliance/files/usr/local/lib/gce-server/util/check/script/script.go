@@ -0,0 +1,365 @@
+/*
+Package script provides a small, declarative script engine for describing
+the pre-flight steps of a gce-xfstests run (image build, config staging,
+kernel copy, invocation) as a text file instead of hard-coding the
+orchestration in Go.
+
+It is a lightweight cousin of the script engine behind cmd/go's script-driven
+tests: a script is a sequence of newline-separated commands that operate on
+a State tracking the current directory, an environment overlay, and the
+stdout/stderr of the most recently completed command.
+
+Supported commands, one per line:
+
+	run cmd arg...     run an external command
+	cp src dst         copy a file
+	mkdir dir          create a directory recursively
+	rm dir             remove a directory and its contents
+	exists path        fail unless path exists
+	env KEY=VALUE      set an environment variable for later commands
+	cd dir             change the working directory
+	bg name cmd arg... start an external command in the background as name
+	wait name          wait for the background command name to finish
+	stdout regexp      fail unless the last command's stdout matches regexp
+	stderr regexp      fail unless the last command's stderr matches regexp
+
+Blank lines and lines starting with '#' are ignored.
+*/
+package script
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"gce-server/util/check"
+)
+
+// bgJob is a background command started with "bg", along with the buffers
+// its stdout and stderr are collected into so "wait" can expose them
+// through the State for later stdout/stderr assertions.
+type bgJob struct {
+	cmd    *check.BackgroundCmd
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+}
+
+// State holds the mutable state threaded through a script run: the working
+// directory, the environment overlay applied on top of the OS environment,
+// the stdout/stderr of the last command that produced any, and the set of
+// commands started in the background with "bg" that haven't been waited on
+// yet.
+type State struct {
+	Dir    string
+	Env    map[string]string
+	Stdout string
+	Stderr string
+
+	log *logrus.Entry
+	bg  map[string]*bgJob
+}
+
+// NewState creates a State rooted at dir, logging each step to log.
+func NewState(dir string, log *logrus.Entry) *State {
+	return &State{
+		Dir: dir,
+		Env: map[string]string{},
+		log: log,
+		bg:  map[string]*bgJob{},
+	}
+}
+
+// resolve turns a script path argument into an absolute path relative to
+// the State's current directory.
+func (s *State) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(s.Dir, path)
+}
+
+// Engine runs a script - a text file of newline-separated commands - against
+// a State, canceling any external command it starts when ctx is done.
+type Engine struct {
+	ctx   context.Context
+	state *State
+}
+
+// NewEngine creates an Engine that executes commands against state.
+func NewEngine(ctx context.Context, state *State) *Engine {
+	return &Engine{ctx: ctx, state: state}
+}
+
+// Run executes script line by line and stops at the first error, wrapping
+// it with the line number and text that produced it. Any "bg" jobs still
+// outstanding when the script ends, because no matching "wait" reaped them,
+// are killed and waited on before Run returns so their goroutine and child
+// process don't leak.
+func (e *Engine) Run(script string) (err error) {
+	defer func() {
+		for name, job := range e.state.bg {
+			job.cmd.Kill()
+			job.cmd.Wait()
+			delete(e.state.bg, name)
+		}
+	}()
+
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e.state.log.WithField("line", lineNum).Debug(line)
+		if err := e.runLine(line); err != nil {
+			return fmt.Errorf("line %d: %q: %w", lineNum, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// runLine dispatches a single script line to the command it names. Verbs
+// that take a single free-form argument ("env", "stdout", "stderr") get the
+// rest of the line verbatim; the rest take shell-like, quote-aware fields so
+// an argument or regexp containing spaces can be quoted instead of being
+// split apart.
+func (e *Engine) runLine(line string) error {
+	verb, rest := splitVerb(line)
+	switch verb {
+	case "run":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdRun(args)
+	case "cp":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdCopy(args)
+	case "mkdir":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdMkdir(args)
+	case "rm":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdRemove(args)
+	case "exists":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdExists(args)
+	case "env":
+		return e.cmdEnv(rest)
+	case "cd":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdCd(args)
+	case "bg":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdBackground(args)
+	case "wait":
+		args, err := splitFields(rest)
+		if err != nil {
+			return err
+		}
+		return e.cmdWait(args)
+	case "stdout":
+		return e.cmdMatch(e.state.Stdout, rest)
+	case "stderr":
+		return e.cmdMatch(e.state.Stderr, rest)
+	default:
+		return fmt.Errorf("unknown command %q", verb)
+	}
+}
+
+// splitVerb splits line into its leading whitespace-delimited verb and the
+// (trimmed) remainder of the line.
+func splitVerb(line string) (verb, rest string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
+
+// splitFields splits line into whitespace-separated fields, honoring single
+// and double quotes the way a shell would, so a quoted field may itself
+// contain whitespace (e.g. run echo "a b").
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, inQuote, quote := false, false, byte(0)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inQuote:
+			if c == quote {
+				inQuote = false
+			} else {
+				field.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			inQuote, quote, inField = true, c, true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func (e *Engine) cmdRun(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("run: missing command")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	err := check.RunContext(e.ctx, cmd, e.state.Dir, e.state.Env, &stdout, &stderr)
+	e.state.Stdout = stdout.String()
+	e.state.Stderr = stderr.String()
+	return err
+}
+
+func (e *Engine) cmdCopy(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("cp: want src dst, got %d args", len(args))
+	}
+	return check.CopyFile(e.state.resolve(args[1]), e.state.resolve(args[0]))
+}
+
+func (e *Engine) cmdMkdir(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("mkdir: want dir, got %d args", len(args))
+	}
+	return check.CreateDir(e.state.resolve(args[0]))
+}
+
+func (e *Engine) cmdRemove(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("rm: want dir, got %d args", len(args))
+	}
+	return check.RemoveDir(e.state.resolve(args[0]))
+}
+
+func (e *Engine) cmdExists(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exists: want path, got %d args", len(args))
+	}
+	path := e.state.resolve(args[0])
+	if !check.FileExists(path) && !check.DirExists(path) {
+		return fmt.Errorf("exists: %s does not exist", path)
+	}
+	return nil
+}
+
+func (e *Engine) cmdEnv(kv string) error {
+	if kv == "" {
+		return fmt.Errorf("env: want KEY=VALUE, got nothing")
+	}
+	idx := strings.IndexByte(kv, '=')
+	if idx < 0 {
+		return fmt.Errorf("env: missing '=' in %q", kv)
+	}
+	key := kv[:idx]
+	if key == "" {
+		return fmt.Errorf("env: missing key in %q", kv)
+	}
+	e.state.Env[key] = kv[idx+1:]
+	return nil
+}
+
+func (e *Engine) cmdCd(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cd: want dir, got %d args", len(args))
+	}
+	dir := e.state.resolve(args[0])
+	if !check.DirExists(dir) {
+		return fmt.Errorf("cd: %s does not exist", dir)
+	}
+	e.state.Dir = dir
+	return nil
+}
+
+func (e *Engine) cmdBackground(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("bg: want name cmd arg..., got %d args", len(args))
+	}
+	name, cmdArgs := args[0], args[1:]
+	if _, dup := e.state.bg[name]; dup {
+		return fmt.Errorf("bg: %s is already running", name)
+	}
+
+	job := &bgJob{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	bg, err := check.StartBackground(e.ctx, cmd, e.state.Dir, e.state.Env, job.stdout, job.stderr)
+	if err != nil {
+		return err
+	}
+	job.cmd = bg
+	e.state.bg[name] = job
+	return nil
+}
+
+func (e *Engine) cmdWait(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("wait: want name, got %d args", len(args))
+	}
+	name := args[0]
+	job, ok := e.state.bg[name]
+	if !ok {
+		return fmt.Errorf("wait: %s is not running", name)
+	}
+	delete(e.state.bg, name)
+
+	err := job.cmd.Wait()
+	e.state.Stdout = job.stdout.String()
+	e.state.Stderr = job.stderr.String()
+	return err
+}
+
+func (e *Engine) cmdMatch(text, pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("want regexp, got nothing")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(text) {
+		return fmt.Errorf("no match for %q in %q", pattern, text)
+	}
+	return nil
+}